@@ -0,0 +1,723 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+	"golang.org/x/text/encoding"
+)
+
+const (
+	// Reconnect delays double from the base up to the max, and reset once a
+	// connection has stayed up for reconnectResetAfter
+	reconnectBaseDelay  = 2 * time.Second
+	reconnectMaxDelay   = 2 * time.Minute
+	reconnectResetAfter = 60 * time.Second
+
+	// How many underscores to try appending when every nick is in use
+	maxNicknameUnderscores = 3
+)
+
+var (
+	// ErrSASLFailed is returned by Run when the server rejects SASL
+	// authentication, as reconnecting would only be rejected again
+	ErrSASLFailed = errors.New("SASL authentication failed")
+	// ErrNotConnected is returned by Send while there is no connection
+	ErrNotConnected = errors.New("not connected to IRC")
+)
+
+// Settings are what a Client connects and registers with
+type Settings struct {
+	// Server is host:port, or just the host to use 6697 with TLS and 6667
+	// without
+	Server        string
+	TLS           bool
+	TLSSkipVerify bool
+	// BindAddress is the local address to connect from
+	BindAddress string
+	// Proxy is a socks5:// URL to connect through
+	Proxy string
+	// AddressFamily is "ipv4" or "ipv6" to only use that, or empty for both
+	AddressFamily string
+
+	Nickname string
+	// AltNicknames are tried in order when the nickname is in use, then the
+	// nickname with underscores appended
+	AltNicknames []string
+	Ident        string
+	Realname     string
+	// Password is sent with PASS
+	Password string
+	// SASLUsername, if set, authenticates with SASL PLAIN
+	SASLUsername string
+	SASLPassword string
+	// WebIRCPassword, if set, sends WEBIRC before registering, for gateways
+	// the server trusts to give their users' addresses
+	WebIRCPassword string
+	WebIRCGateway  string
+	WebIRCHostname string
+	WebIRCIP       string
+	// Capabilities are the IRCv3 capabilities to request. sasl is requested
+	// as well when SASLUsername is set.
+	Capabilities []string
+
+	// Encoding is the network's character encoding, UTF-8 if nil
+	Encoding encoding.Encoding
+	// PingTimeout is how long the server may stay silent before the
+	// connection is assumed dead
+	PingTimeout time.Duration
+	// PingInterval, if set, is how often to PING the server
+	PingInterval time.Duration
+	WriteTimeout time.Duration
+	// MaxLineLength is the longest line read; longer ones are discarded
+	MaxLineLength int
+	// MaxReconnectAttempts, if set, is how many reconnects in a row may fail
+	// before Run gives up
+	MaxReconnectAttempts int
+}
+
+// Limiter paces the lines a Client sends
+type Limiter interface {
+	// Wait blocks until another line may be sent
+	Wait()
+}
+
+// Client is a connection to an IRC server that registers, answers PINGs,
+// keeps track of its nick and reconnects when the connection is lost. What
+// to do with the lines received is up to Handler.
+type Client struct {
+	// Handler is called with each line received, after the client has
+	// dealt with the parts that concern the connection itself
+	Handler func(line string)
+	// Limiter, if set, paces the lines sent
+	Limiter Limiter
+	// OnRegister is called when the server welcomes us on a new connection
+	OnRegister func()
+	// OnDisconnect is called when a connection that got as far as
+	// registering, or trying to, has ended, with whether it was registered
+	OnDisconnect func(registered bool)
+	// OnReconnect is called before each attempt to connect again
+	OnReconnect func()
+	Log         *slog.Logger
+
+	// settings is called again for every connection and write, so changes
+	// apply without restarting the client
+	settings func() Settings
+	// quit is closed by Quit. Cancelling ctx does the same without sending
+	// QUIT.
+	quit chan struct{}
+	ctx  context.Context
+	// done is closed once Run has returned
+	done chan struct{}
+
+	mutex sync.Mutex
+	// conn is replaced each time a new connection is established
+	conn Writer
+	// lastPong is when the server last answered one of our PINGs
+	lastPong time.Time
+	// nickname is the nick we're registered (or registering) with, and
+	// nickAttempts counts fallbacks tried after "nickname in use" errors
+	nickname     string
+	nickAttempts int
+	// registered is set once the server has welcomed us (001)
+	registered bool
+	// nextDelay, if set, is how long the next reconnect must wait at least
+	nextDelay time.Duration
+}
+
+// NewClient returns a Client that connects with the settings returned by
+// settings once Run is called. Cancelling ctx shuts it down.
+func NewClient(ctx context.Context, settings func() Settings) *Client {
+	return &Client{
+		Log:      slog.Default(),
+		settings: settings,
+		quit:     make(chan struct{}),
+		ctx:      ctx,
+		done:     make(chan struct{}),
+	}
+}
+
+// Attach makes w the client's connection, registered as nick, as if Run had
+// connected. Lines can then be fed to HandleLine, so a client and its
+// Handler can be driven without a server, as in tests.
+func (c *Client) Attach(w Writer, nick string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn = w
+	c.nickname = nick
+	c.registered = true
+}
+
+// Done is closed once Run has returned
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Registered reports whether the connection is up and registered
+func (c *Client) Registered() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.registered
+}
+
+// Nick returns the nick we're registered with
+func (c *Client) Nick() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.nickname
+}
+
+// ShuttingDown reports whether Quit has been called or the client's context
+// cancelled
+func (c *Client) ShuttingDown() bool {
+	select {
+	case <-c.quit:
+		return true
+	default:
+		return c.ctx.Err() != nil
+	}
+}
+
+// DelayReconnect makes the next reconnect wait for the whole of delay,
+// instead of the usual backoff, as after a ban or throttling
+func (c *Client) DelayReconnect(delay time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.nextDelay = delay
+}
+
+// ReconnectDelay returns the delay set by DelayReconnect for the next
+// reconnect, or 0 if there is none
+func (c *Client) ReconnectDelay() time.Duration {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.nextDelay
+}
+
+// Send writes a command to the current connection. Writes are serialized so
+// lines from different goroutines don't interleave.
+func (c *Client) Send(format string, args ...interface{}) error {
+	line := EncodeLine(fmt.Sprintf(format, args...), c.encoding())
+	c.waitToSend()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn == nil {
+		return ErrNotConnected
+	}
+	return c.writeLine(c.conn, "%s", line)
+}
+
+// Reconnect sends QUIT to the server and closes the connection, so Run
+// connects again
+func (c *Client) Reconnect(reason string) {
+	c.waitToSend()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.writeLine(c.conn, "QUIT :%s", reason)
+		c.conn.Close()
+	}
+}
+
+// Quit sends QUIT to the server, closes the connection and stops Run
+func (c *Client) Quit(reason string) {
+	c.waitToSend()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.ShuttingDown() {
+		return
+	}
+	close(c.quit)
+	if c.conn != nil {
+		c.writeLine(c.conn, "QUIT :%s", reason)
+		c.conn.Close()
+	}
+}
+
+// HandleLine deals with a line received from the server: answering PINGs,
+// noting PONGs, registration and nick changes. It then passes the line on
+// to Handler.
+func (c *Client) HandleLine(line string) {
+	msg := ParseLine(line)
+	switch msg.Command {
+	case "PING":
+		// Respond to PING messages to avoid being disconnected. The reply
+		// skips the Limiter, as queued behind a burst of messages it could
+		// arrive too late.
+		c.pong(msg.Param(0))
+
+	case "PONG":
+		c.mutex.Lock()
+		c.lastPong = time.Now()
+		c.mutex.Unlock()
+
+	case "001":
+		// RPL_WELCOME confirms registration and the nick we registered with
+		c.mutex.Lock()
+		c.nickname = msg.Param(0)
+		wasRegistered := c.registered
+		c.registered = true
+		// A throttling warning is moot once we're in
+		c.nextDelay = 0
+		c.mutex.Unlock()
+		if !wasRegistered && c.OnRegister != nil {
+			c.OnRegister()
+		}
+
+	case "433":
+		// ERR_NICKNAMEINUSE
+		c.retryNickname()
+
+	case "NICK":
+		// Our own nick can be changed after registration, by services or a
+		// forced SVSNICK, and echoes of what we send come from the new one
+		c.mutex.Lock()
+		if strings.EqualFold(msg.Nick(), c.nickname) {
+			c.nickname = msg.Param(0)
+		}
+		c.mutex.Unlock()
+	}
+	if c.Handler != nil {
+		c.Handler(line)
+	}
+}
+
+// pong answers a PING straight away
+func (c *Client) pong(token string) {
+	line := EncodeLine("PONG :"+token, c.encoding())
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		c.writeLine(c.conn, "%s", line)
+	}
+}
+
+// Run connects to the server and handles lines until the context is
+// cancelled or Quit is called, reconnecting whenever the connection is
+// lost. It returns nil once shut down, or an error if it gives up: when the
+// first connection fails, SASL authentication is rejected or
+// MaxReconnectAttempts reconnects in a row fail.
+func (c *Client) Run() error {
+	defer close(c.done)
+	firstConnection := true
+	delay := reconnectBaseDelay
+	// attempts counts reconnects since we were last registered
+	attempts := 0
+	// required is how long the server has told us to stay away, after a ban
+	// or throttling
+	var required time.Duration
+	retry := func() error {
+		if max := c.settings().MaxReconnectAttempts; max > 0 && attempts >= max {
+			return fmt.Errorf("%d reconnects in a row failed", attempts)
+		}
+		attempts++
+		delay = c.waitToReconnect(delay, required)
+		required = 0
+		return nil
+	}
+
+	for !c.ShuttingDown() {
+		if !firstConnection && c.OnReconnect != nil {
+			c.OnReconnect()
+		}
+		settings := c.settings()
+		conn, err := dial(c.ctx, settings)
+		if err != nil {
+			if c.ShuttingDown() {
+				return nil
+			}
+			if firstConnection {
+				return fmt.Errorf("failed to establish initial IRC connection: %w", err)
+			}
+			c.Log.Error("Failed to connect to IRC server", "server", settings.Server, "err", err)
+			if err := retry(); err != nil {
+				return err
+			}
+			continue
+		}
+
+		c.mutex.Lock()
+		if c.ShuttingDown() {
+			c.mutex.Unlock()
+			conn.Close()
+			return nil
+		}
+		c.conn = conn
+		c.nickname = settings.Nickname
+		c.nickAttempts = 0
+		c.registered = false
+		c.mutex.Unlock()
+		// Closing the connection on cancellation unblocks any read
+		stopCloseOnCancel := context.AfterFunc(c.ctx, func() { conn.Close() })
+
+		reader := bufio.NewReaderSize(conn, settings.MaxLineLength)
+		c.register(conn, settings)
+		if settings.SASLUsername != "" {
+			if err := c.authenticateSASL(conn, reader, settings); err != nil {
+				if errors.Is(err, ErrSASLFailed) {
+					conn.Close()
+					return err
+				}
+				stopCloseOnCancel()
+				if c.ShuttingDown() {
+					return nil
+				}
+				c.Log.Error("Error during SASL authentication", "err", err)
+				conn.Close()
+				if err := retry(); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		firstConnection = false
+
+		// Handle incoming IRC messages
+		connectedAt := time.Now()
+		stopKeepAlive := make(chan struct{})
+		if settings.PingInterval > 0 {
+			go c.keepAlive(conn, settings.PingInterval, stopKeepAlive)
+		}
+		for {
+			line, err := ReadLine(conn, reader, settings.PingTimeout)
+			if errors.Is(err, ErrLineTooLong) {
+				c.Log.Warn("Discarding IRC line longer than max_line_length", "max_line_length", settings.MaxLineLength)
+				continue
+			}
+			if line != "" {
+				// A final line without a newline still arrives with the
+				// error, so handle it before reconnecting
+				c.HandleLine(DecodeLine(line, c.encoding()))
+			}
+			if err != nil {
+				if c.ShuttingDown() {
+					break
+				}
+				if errors.Is(err, io.EOF) {
+					c.Log.Warn("IRC server closed the connection")
+				} else {
+					c.Log.Error("Error reading from IRC", "err", err)
+				}
+				break
+			}
+		}
+		close(stopKeepAlive)
+		c.mutex.Lock()
+		wasRegistered := c.registered
+		if wasRegistered {
+			attempts = 0
+		}
+		c.registered = false
+		c.mutex.Unlock()
+		if c.OnDisconnect != nil {
+			c.OnDisconnect(wasRegistered)
+		}
+
+		stopCloseOnCancel()
+		conn.Close()
+		if c.ShuttingDown() {
+			c.Log.Info("IRC connection closed")
+			return nil
+		}
+
+		// If we get here, the connection was lost
+		c.Log.Warn("IRC connection lost")
+		if time.Since(connectedAt) >= reconnectResetAfter {
+			delay = reconnectBaseDelay
+		}
+		c.mutex.Lock()
+		required = c.nextDelay
+		c.nextDelay = 0
+		c.mutex.Unlock()
+		if err := retry(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// register sends everything up to and including USER, and CAP END unless
+// SASL authentication comes first
+func (c *Client) register(conn Writer, settings Settings) {
+	// WEBIRC has to come before anything else
+	if settings.WebIRCPassword != "" {
+		ip := settings.WebIRCIP
+		if strings.HasPrefix(ip, ":") {
+			// A parameter can't start with ":", so "::1" is sent as "0::1"
+			ip = "0" + ip
+		}
+		hostname := settings.WebIRCHostname
+		if hostname == "" {
+			hostname = ip
+		}
+		c.sendLine(conn, "WEBIRC %s %s %s %s", settings.WebIRCPassword, settings.WebIRCGateway, hostname, ip)
+	}
+	if settings.Password != "" {
+		c.sendLine(conn, "PASS %s", settings.Password)
+	}
+	// Capabilities are requested one at a time, as a server rejects the
+	// whole request if it doesn't support one of them
+	capabilities := settings.Capabilities
+	if settings.SASLUsername != "" {
+		capabilities = append(capabilities[:len(capabilities):len(capabilities)], "sasl")
+	}
+	for _, capability := range capabilities {
+		c.sendLine(conn, "CAP REQ :%s", capability)
+	}
+	c.sendLine(conn, "NICK %s", settings.Nickname)
+	c.sendLine(conn, "USER %s 8 * :%s", settings.Ident, settings.Realname)
+	if settings.SASLUsername == "" {
+		// With SASL, negotiation ends once authentication succeeds
+		c.sendLine(conn, "CAP END")
+	}
+}
+
+// authenticateSASL performs the SASL PLAIN handshake after CAP REQ :sasl has
+// been sent, and ends capability negotiation on success
+func (c *Client) authenticateSASL(conn net.Conn, reader *bufio.Reader, settings Settings) error {
+	for {
+		line, err := ReadLine(conn, reader, settings.PingTimeout)
+		if errors.Is(err, ErrLineTooLong) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		c.Log.Debug("IRC line received", "line", line)
+
+		msg := ParseLine(line)
+		switch msg.Command {
+		case "PING":
+			c.pong(msg.Param(0))
+		case "CAP":
+			// Only the answer to our sasl request matters here
+			if !strings.Contains(" "+msg.Param(2)+" ", " sasl ") {
+				continue
+			}
+			switch msg.Param(1) {
+			case "ACK":
+				c.sendLine(conn, "AUTHENTICATE PLAIN")
+			case "NAK":
+				return fmt.Errorf("%w: server does not support SASL", ErrSASLFailed)
+			}
+		case "AUTHENTICATE":
+			if msg.Param(0) == "+" {
+				credentials := settings.SASLUsername + "\x00" + settings.SASLUsername + "\x00" + settings.SASLPassword
+				c.sendLine(conn, "AUTHENTICATE %s", base64.StdEncoding.EncodeToString([]byte(credentials)))
+			}
+		case "001":
+			// A server that doesn't know CAP ignores it and registers us
+			// without authenticating
+			return fmt.Errorf("%w: server does not support SASL", ErrSASLFailed)
+		case "421":
+			// ERR_UNKNOWNCOMMAND, from a server that doesn't know CAP or
+			// AUTHENTICATE
+			if command := strings.ToUpper(msg.Param(1)); command == "CAP" || command == "AUTHENTICATE" {
+				return fmt.Errorf("%w: server does not support SASL", ErrSASLFailed)
+			}
+		case "433":
+			c.retryNickname()
+		case "903":
+			c.Log.Info("SASL authentication successful", "user", settings.SASLUsername)
+			c.sendLine(conn, "CAP END")
+			return nil
+		case "902", "904", "905", "906", "908":
+			return fmt.Errorf("%w: %s", ErrSASLFailed, strings.TrimSpace(line))
+		}
+	}
+}
+
+// retryNickname handles a "nickname in use" error during registration by
+// trying the alternate nicks in order, then appending underscores
+func (c *Client) retryNickname() {
+	alternates := c.settings().AltNicknames
+	c.waitToSend()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.nickAttempts >= len(alternates)+maxNicknameUnderscores {
+		c.Log.Error("Nickname is in use and no alternatives are left", "nick", c.nickname)
+		return
+	}
+
+	previous := c.nickname
+	if c.nickAttempts < len(alternates) {
+		c.nickname = alternates[c.nickAttempts]
+	} else {
+		c.nickname += "_"
+	}
+	c.nickAttempts++
+	c.Log.Warn("Nickname is in use, trying another", "nick", previous, "next", c.nickname)
+	c.writeLine(c.conn, "NICK %s", c.nickname)
+}
+
+// keepAlive sends a PING to the server every interval and closes the
+// connection, triggering a reconnect, if the previous PING went unanswered
+func (c *Client) keepAlive(conn Writer, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var pingSent time.Time
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mutex.Lock()
+		if !pingSent.IsZero() && c.lastPong.Before(pingSent) {
+			c.mutex.Unlock()
+			c.Log.Warn("No PONG received, reconnecting", "interval", interval)
+			conn.Close()
+			return
+		}
+		c.mutex.Unlock()
+		c.waitToSend()
+		c.mutex.Lock()
+		pingSent = time.Now()
+		c.writeLine(conn, "PING :keepalive")
+		c.mutex.Unlock()
+	}
+}
+
+// waitToReconnect sleeps for reconnectWait(delay, required), returning early
+// if the client is shutting down, and returns the next backoff delay to use
+func (c *Client) waitToReconnect(delay, required time.Duration) time.Duration {
+	wait := reconnectWait(delay, required)
+	c.Log.Info("Reconnecting", "delay", wait.Round(time.Millisecond))
+	select {
+	case <-time.After(wait):
+	case <-c.quit:
+	case <-c.ctx.Done():
+	}
+
+	delay *= 2
+	if delay > reconnectMaxDelay {
+		delay = reconnectMaxDelay
+	}
+	return delay
+}
+
+// reconnectWait returns how long to wait before reconnecting: somewhere
+// between half and all of the backoff delay, so clients dropped together
+// don't all come back at once. When the server has told us to wait, for a
+// ban or throttling, that wait is always served in full, with up to a tenth
+// more added as jitter.
+func reconnectWait(delay, required time.Duration) time.Duration {
+	if required > 0 {
+		return required + time.Duration(rand.Int63n(int64(required/10)+1))
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// sendLine writes a single command to conn once the Limiter allows it
+func (c *Client) sendLine(conn Writer, format string, args ...interface{}) error {
+	c.waitToSend()
+	return c.writeLine(conn, format, args...)
+}
+
+// waitToSend blocks until the Limiter allows another line. Callers that hold
+// the mutex while writing must wait first, so a throttled send doesn't keep
+// everything else that needs the mutex waiting too.
+func (c *Client) waitToSend() {
+	if c.Limiter != nil {
+		c.Limiter.Wait()
+	}
+}
+
+// writeLine writes a single command to conn straight away, giving up and
+// closing the connection after WriteTimeout
+func (c *Client) writeLine(conn Writer, format string, args ...interface{}) error {
+	return WriteLine(conn, fmt.Sprintf(format, args...), c.settings().WriteTimeout)
+}
+
+// encoding returns the network's encoding
+func (c *Client) encoding() encoding.Encoding {
+	if enc := c.settings().Encoding; enc != nil {
+		return enc
+	}
+	return encoding.Nop
+}
+
+// dial opens a plain or TLS connection to the server, giving up if ctx is
+// cancelled
+func dial(ctx context.Context, settings Settings) (net.Conn, error) {
+	address := serverAddress(settings)
+	netDialer := &net.Dialer{}
+	if settings.BindAddress != "" {
+		netDialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(settings.BindAddress)}
+	}
+	var dialer proxy.Dialer = netDialer
+	if settings.Proxy != "" {
+		proxyURL, err := url.Parse(settings.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL: %w", err)
+		}
+		if dialer, err = proxy.FromURL(proxyURL, netDialer); err != nil {
+			return nil, fmt.Errorf("error setting up proxy: %w", err)
+		}
+	}
+	var conn net.Conn
+	var err error
+	if contextDialer, ok := dialer.(proxy.ContextDialer); ok {
+		conn, err = contextDialer.DialContext(ctx, network(settings), address)
+	} else {
+		conn, err = dialer.Dial(network(settings), address)
+	}
+	if err != nil || !settings.TLS {
+		return conn, err
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: settings.TLSSkipVerify,
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// network returns the network to dial for the address family
+func network(settings Settings) string {
+	switch settings.AddressFamily {
+	case "ipv4":
+		return "tcp4"
+	case "ipv6":
+		return "tcp6"
+	}
+	return "tcp"
+}
+
+// serverAddress returns the server address, adding the default port (6697
+// for TLS, 6667 otherwise) when none is given
+func serverAddress(settings Settings) string {
+	if _, _, err := net.SplitHostPort(settings.Server); err == nil {
+		return settings.Server
+	}
+	port := "6667"
+	if settings.TLS {
+		port = "6697"
+	}
+	return net.JoinHostPort(strings.Trim(settings.Server, "[]"), port)
+}
@@ -0,0 +1,240 @@
+package irc
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReconnectWait(t *testing.T) {
+	tests := []struct {
+		name     string
+		delay    time.Duration
+		required time.Duration
+		min, max time.Duration
+	}{
+		{"backoff", 8 * time.Second, 0, 4 * time.Second, 8 * time.Second},
+		// The backoff delay is much shorter, but mustn't shorten the wait
+		{"throttled", reconnectBaseDelay, 5 * time.Minute, 5 * time.Minute, 5*time.Minute + 30*time.Second},
+		{"banned", reconnectMaxDelay, 30 * time.Minute, 30 * time.Minute, 33 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				if wait := reconnectWait(tt.delay, tt.required); wait < tt.min || wait > tt.max {
+					t.Fatalf("reconnectWait(%s, %s) = %s, want between %s and %s", tt.delay, tt.required, wait, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestClientRegisters(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	settings := Settings{
+		Server:        listener.Addr().String(),
+		Nickname:      "bot",
+		AltNicknames:  []string{"bot2"},
+		Ident:         "bridge",
+		Realname:      "IRC bridge",
+		Capabilities:  []string{"server-time"},
+		PingTimeout:   5 * time.Second,
+		WriteTimeout:  time.Second,
+		MaxLineLength: 512,
+	}
+	client := NewClient(context.Background(), func() Settings { return settings })
+	handled := make(chan string, 10)
+	client.Handler = func(line string) { handled <- line }
+	registered := make(chan struct{})
+	client.OnRegister = func() { close(registered) }
+	go client.Run()
+	defer func() {
+		client.Quit("bye")
+		<-client.Done()
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	reader := bufio.NewReader(conn)
+	readLines := func(n int) []string {
+		t.Helper()
+		var lines []string
+		for i := 0; i < n; i++ {
+			line, err := ReadLine(conn, reader, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	want := []string{"CAP REQ :server-time", "NICK bot", "USER bridge 8 * :IRC bridge", "CAP END"}
+	if got := readLines(len(want)); !slices.Equal(got, want) {
+		t.Fatalf("registration sent %q, want %q", got, want)
+	}
+	conn.Write([]byte(":irc.example.org 433 * bot :Nickname is already in use\r\n"))
+	if got := readLines(1)[0]; got != "NICK bot2" {
+		t.Fatalf("after 433 sent %q, want NICK bot2", got)
+	}
+	conn.Write([]byte(":irc.example.org 001 bot2 :Welcome\r\nPING :token\r\n"))
+	if got := readLines(1)[0]; got != "PONG :token" {
+		t.Fatalf("answered PING with %q, want PONG :token", got)
+	}
+	<-registered
+	if !client.Registered() || client.Nick() != "bot2" {
+		t.Errorf("after 001 Registered() = %v, Nick() = %q, want true and bot2", client.Registered(), client.Nick())
+	}
+	// Every line is passed on, including the ones the client dealt with
+	for _, want := range []string{":irc.example.org 433 * bot :Nickname is already in use", ":irc.example.org 001 bot2 :Welcome", "PING :token"} {
+		if got := <-handled; got != want {
+			t.Errorf("Handler got %q, want %q", got, want)
+		}
+	}
+}
+
+// blockedLimiter never allows another line
+type blockedLimiter struct{}
+
+func (blockedLimiter) Wait() { select {} }
+
+func TestPongSkipsLimiter(t *testing.T) {
+	client := NewClient(context.Background(), func() Settings { return Settings{WriteTimeout: time.Second} })
+	client.Limiter = blockedLimiter{}
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	client.Attach(local, "bot")
+
+	go client.HandleLine("PING :token")
+	line, err := ReadLine(remote, bufio.NewReader(remote), time.Second)
+	if err != nil {
+		t.Fatalf("no PONG while the limiter is holding lines back: %v", err)
+	}
+	if line != "PONG :token" {
+		t.Errorf("answered PING with %q, want PONG :token", line)
+	}
+}
+
+func TestReconnectUsesNewSettings(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	var mutex sync.Mutex
+	settings := Settings{
+		Server:        listener.Addr().String(),
+		Nickname:      "bot",
+		PingTimeout:   5 * time.Second,
+		WriteTimeout:  time.Second,
+		MaxLineLength: 512,
+	}
+	client := NewClient(context.Background(), func() Settings {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return settings
+	})
+	go client.Run()
+	defer func() {
+		client.Quit("bye")
+		<-client.Done()
+	}()
+
+	// firstNick accepts a connection and returns the nick it registers with
+	firstNick := func() string {
+		t.Helper()
+		conn, err := listener.Accept()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			line, err := ReadLine(conn, reader, 5*time.Second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if nick, ok := strings.CutPrefix(line, "NICK "); ok {
+				return nick
+			}
+		}
+	}
+	if nick := firstNick(); nick != "bot" {
+		t.Fatalf("registered as %q, want bot", nick)
+	}
+	mutex.Lock()
+	settings.Nickname = "renamed"
+	mutex.Unlock()
+	if nick := firstNick(); nick != "renamed" {
+		t.Errorf("reconnected as %q, want the new nickname", nick)
+	}
+}
+
+func TestSASLWithoutCAP(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+	}{
+		{"registered straight away", ":irc.example.org 001 bot :Welcome"},
+		{"CAP unknown", ":irc.example.org 421 * CAP :Unknown command"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			go func() {
+				conn, err := listener.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				conn.Write([]byte(tt.reply + "\r\n"))
+				io.Copy(io.Discard, conn)
+			}()
+
+			client := NewClient(context.Background(), func() Settings {
+				return Settings{
+					Server:        listener.Addr().String(),
+					Nickname:      "bot",
+					SASLUsername:  "bot",
+					SASLPassword:  "secret",
+					PingTimeout:   time.Minute,
+					WriteTimeout:  time.Second,
+					MaxLineLength: 512,
+				}
+			})
+			result := make(chan error, 1)
+			go func() { result <- client.Run() }()
+			select {
+			case err := <-result:
+				if !errors.Is(err, ErrSASLFailed) {
+					t.Errorf("Run() = %v, want ErrSASLFailed", err)
+				}
+			case <-time.After(5 * time.Second):
+				client.Quit("bye")
+				t.Fatal("still waiting for SASL after the server answered without it")
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package irc
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding"
+)
+
+// Writer is the write side of an IRC connection. A net.Conn satisfies it;
+// anything else that does, such as a buffer recording what was sent, lets
+// a client run without a server.
+type Writer interface {
+	io.Writer
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// ErrUnsafeLine is returned by WriteLine for a line containing CR, LF or NUL,
+// which would let the rest of it be read as another command
+var ErrUnsafeLine = errors.New("line contains CR, LF or NUL")
+
+// ErrLineTooLong is returned by ReadLine for a line that didn't fit in the
+// reader's buffer. The line is discarded, and the next one can be read.
+var ErrLineTooLong = errors.New("line from server too long, discarded")
+
+// ReadLine reads a line from the server, failing if nothing arrives within
+// timeout so that silently dropped connections are noticed. Lines are
+// limited to the size of reader's buffer (see bufio.NewReaderSize), so a
+// server that never sends a newline can't use up memory.
+func ReadLine(conn net.Conn, reader *bufio.Reader, timeout time.Duration) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	data, err := reader.ReadSlice('\n')
+	line := string(data)
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// Skip to the end of the line, still within the deadline
+		for errors.Is(err, bufio.ErrBufferFull) {
+			_, err = reader.ReadSlice('\n')
+		}
+		if err == nil {
+			return "", ErrLineTooLong
+		}
+		line = ""
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "", fmt.Errorf("nothing received from server in %s, assuming connection is dead", timeout)
+	}
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// WriteLine writes a single line to w, terminated with CRLF as the protocol
+// requires. Lines containing CR, LF or NUL are refused with ErrUnsafeLine.
+// If the write doesn't finish within timeout, for example because the
+// server stopped reading, w is closed so the reader notices too.
+func WriteLine(w Writer, line string, timeout time.Duration) error {
+	if strings.ContainsAny(line, "\r\n\x00") {
+		return ErrUnsafeLine
+	}
+	w.SetWriteDeadline(time.Now().Add(timeout))
+	_, err := io.WriteString(w, line+"\r\n")
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		w.Close()
+		return fmt.Errorf("write to server timed out after %s, assuming connection is dead", timeout)
+	}
+	return err
+}
+
+// DecodeLine converts a line received from IRC to UTF-8. Bytes that aren't
+// valid in the encoding are replaced with U+FFFD, so the result is always
+// valid UTF-8.
+func DecodeLine(line string, enc encoding.Encoding) string {
+	decoded, err := enc.NewDecoder().String(line)
+	if err != nil {
+		return strings.ToValidUTF8(line, "\uFFFD")
+	}
+	return strings.ToValidUTF8(decoded, "\uFFFD")
+}
+
+// EncodeLine converts a line to the IRC network's encoding, replacing
+// characters it can't represent
+func EncodeLine(line string, enc encoding.Encoding) string {
+	encoded, err := encoding.ReplaceUnsupported(enc.NewEncoder()).String(line)
+	if err != nil {
+		return line
+	}
+	return encoded
+}
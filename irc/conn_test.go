@@ -0,0 +1,33 @@
+package irc
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadLineTooLong(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go func() {
+		defer server.Close()
+		server.Write([]byte(":nick!u@h PRIVMSG #c :" + strings.Repeat("x", 100) + "\r\n"))
+		server.Write([]byte(":nick!u@h PRIVMSG #c :next\r\n"))
+	}()
+
+	// bufio's minimum buffer size is 16 bytes
+	reader := bufio.NewReaderSize(client, 32)
+	if _, err := ReadLine(client, reader, time.Second); !errors.Is(err, ErrLineTooLong) {
+		t.Fatalf("ReadLine of a long line returned %v, want ErrLineTooLong", err)
+	}
+	line, err := ReadLine(client, reader, time.Second)
+	if err != nil {
+		t.Fatalf("ReadLine after a long line returned %v", err)
+	}
+	if want := ":nick!u@h PRIVMSG #c :next"; line != want {
+		t.Errorf("ReadLine after a long line = %q, want %q", line, want)
+	}
+}
@@ -0,0 +1,150 @@
+// Package irc is the client side of the IRC protocol: parsing and writing
+// lines, and a Client that connects, registers and reconnects. What to do
+// with the messages received is left to the caller.
+package irc
+
+import (
+	"strings"
+	"time"
+)
+
+// Message is a parsed IRC protocol line
+type Message struct {
+	// Tags holds IRCv3 message tags, with values unescaped
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string
+	// Trailing is the last parameter, whether or not it was sent with a
+	// leading colon
+	Trailing string
+	// Time is when the message was sent, from the IRCv3 server-time tag,
+	// or else when it was received
+	Time time.Time
+}
+
+// ParseLine splits a raw IRC line into its tags, prefix, command, middle
+// parameters and trailing parameter, taking the time from the server-time
+// tag if there is one
+func ParseLine(line string) Message {
+	var msg Message
+	line = strings.TrimRight(line, "\r\n")
+
+	if strings.HasPrefix(line, "@") {
+		end := strings.Index(line, " ")
+		if end == -1 {
+			return msg
+		}
+		msg.Tags = parseTags(line[1:end])
+		if t, err := time.Parse(time.RFC3339Nano, msg.Tags["time"]); err == nil {
+			msg.Time = t
+		}
+		line = strings.TrimLeft(line[end+1:], " ")
+	}
+
+	if strings.HasPrefix(line, ":") {
+		end := strings.Index(line, " ")
+		if end == -1 {
+			msg.Prefix = line[1:]
+			return msg
+		}
+		msg.Prefix = line[1:end]
+		line = line[end+1:]
+	}
+
+	// The trailing parameter follows the first " :" and may contain spaces
+	// and colons (e.g. URLs or IPv6 addresses)
+	hasTrailing := false
+	if idx := strings.Index(line, " :"); idx != -1 {
+		msg.Trailing = line[idx+2:]
+		line = line[:idx]
+		hasTrailing = true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) > 0 {
+		msg.Command = strings.ToUpper(fields[0])
+		msg.Params = fields[1:]
+	}
+	// Servers may leave out the colon when the last parameter has no spaces,
+	// as in "PRIVMSG #channel hello"
+	if !hasTrailing && len(msg.Params) > 0 {
+		msg.Trailing = msg.Params[len(msg.Params)-1]
+		msg.Params = msg.Params[:len(msg.Params)-1]
+	}
+	return msg
+}
+
+// parseTags parses the tags of an IRCv3 message (without the leading @),
+// unescaping their values. Tags without a value map to "".
+func parseTags(tags string) map[string]string {
+	parsed := make(map[string]string)
+	for _, tag := range strings.Split(tags, ";") {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, "=")
+		parsed[key] = unescapeTagValue(value)
+	}
+	return parsed
+}
+
+// unescapeTagValue reverses the escaping of IRCv3 tag values: \: for ;,
+// \s for space, \\ for \, and \r and \n. A backslash before any other
+// character is dropped, as is a trailing backslash.
+func unescapeTagValue(value string) string {
+	if !strings.Contains(value, "\\") {
+		return value
+	}
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' {
+			out.WriteByte(value[i])
+			continue
+		}
+		i++
+		if i == len(value) {
+			break
+		}
+		switch value[i] {
+		case ':':
+			out.WriteByte(';')
+		case 's':
+			out.WriteByte(' ')
+		case 'r':
+			out.WriteByte('\r')
+		case 'n':
+			out.WriteByte('\n')
+		default:
+			out.WriteByte(value[i])
+		}
+	}
+	return out.String()
+}
+
+// IsChannel reports whether an IRC message target is a channel rather than
+// a nick
+func IsChannel(target string) bool {
+	return target != "" && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// Nick returns the nickname from the message prefix. Server prefixes (no "!"
+// or "@") return the server name, and lines without a prefix return "".
+func (m Message) Nick() string {
+	if end := strings.IndexAny(m.Prefix, "!@"); end != -1 {
+		return m.Prefix[:end]
+	}
+	return m.Prefix
+}
+
+// Param returns the i'th parameter, treating the trailing parameter as the
+// last one, or "" if there is no such parameter
+func (m Message) Param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	if i == len(m.Params) {
+		return m.Trailing
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+package irc
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNick(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"privmsg", ":alice!alice@example.org PRIVMSG #chan :hello", "alice"},
+		{"prefix without user", ":alice@example.org PRIVMSG #chan :hello", "alice"},
+		{"server notice", ":irc.example.org NOTICE * :*** Looking up your hostname", "irc.example.org"},
+		{"numeric", ":irc.example.org 001 bot :Welcome to the network", "irc.example.org"},
+		{"numeric with tags", "@time=2024-01-01T00:00:00.000Z :irc.example.org 353 bot = #chan :alice bob", "irc.example.org"},
+		{"no prefix", "PING :irc.example.org", ""},
+		{"empty prefix", ": PRIVMSG #chan :hello", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseLine(tt.line).Nick(); got != tt.want {
+				t.Errorf("Nick() of %q = %q, want %q", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLineTrailing(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		command  string
+		params   []string
+		trailing string
+	}{
+		{"url with port", ":nick!u@h PRIVMSG #c :http://example.com:8080/x", "PRIVMSG", []string{"#c"}, "http://example.com:8080/x"},
+		{"colons in text", ":nick!u@h PRIVMSG #c :note: see 10:30 :)", "PRIVMSG", []string{"#c"}, "note: see 10:30 :)"},
+		{"ipv6 address", ":nick!u@h PRIVMSG #c :connect to ::1 or [2001:db8::1]:6697", "PRIVMSG", []string{"#c"}, "connect to ::1 or [2001:db8::1]:6697"},
+		{"no prefix", "PRIVMSG #c :http://example.com:8080/x", "PRIVMSG", []string{"#c"}, "http://example.com:8080/x"},
+		{"no colon before last param", ":nick!u@h PRIVMSG #c hello", "PRIVMSG", []string{"#c"}, "hello"},
+		{"empty trailing", ":nick!u@h PRIVMSG #c :", "PRIVMSG", []string{"#c"}, ""},
+		{"crlf", ":nick!u@h PRIVMSG #c :hi\r\n", "PRIVMSG", []string{"#c"}, "hi"},
+		{"tags", "@msgid=abc;time=2024-01-01T00:00:00.000Z :nick!u@h PRIVMSG #c :a:b", "PRIVMSG", []string{"#c"}, "a:b"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := ParseLine(tt.line)
+			if msg.Command != tt.command {
+				t.Errorf("Command = %q, want %q", msg.Command, tt.command)
+			}
+			if !slices.Equal(msg.Params, tt.params) {
+				t.Errorf("Params = %q, want %q", msg.Params, tt.params)
+			}
+			if msg.Trailing != tt.trailing {
+				t.Errorf("Trailing = %q, want %q", msg.Trailing, tt.trailing)
+			}
+		})
+	}
+}
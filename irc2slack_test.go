@@ -0,0 +1,421 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"irctoslack/irc"
+	"irctoslack/slack"
+)
+
+const testConfig = `
+irc:
+  server: "irc.example.org:6667"
+  nickname: "bot"
+  channels: ["#chan"]
+slack:
+  webhook_url: "https://hooks.slack.com/services/T000/B000/XXXX"
+`
+
+// recordingWriter is an irc.Writer that keeps the lines written to it
+// instead of sending them
+type recordingWriter struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *recordingWriter) SetWriteDeadline(time.Time) error { return nil }
+
+func (w *recordingWriter) Close() error { return nil }
+
+// Lines returns the lines written so far without their CRLF
+func (w *recordingWriter) Lines() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	lines := strings.SplitAfter(w.buf.String(), "\r\n")
+	lines = lines[:len(lines)-1]
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r\n")
+	}
+	return lines
+}
+
+// recordingSink is a slackSink that keeps the payloads posted to it
+type recordingSink struct {
+	mutex sync.Mutex
+	posts []recordedPost
+}
+
+type recordedPost struct {
+	payload     slack.Payload
+	destination string
+}
+
+func (s *recordingSink) Post(payload slack.Payload, destination string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.posts = append(s.posts, recordedPost{payload, destination})
+	return "", nil
+}
+
+// Take waits for the Slack queue to empty, then returns and forgets the
+// payloads posted so far
+func (s *recordingSink) Take(t *testing.T) []recordedPost {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for slackQueue.Len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Slack queue still holds %d posts", slackQueue.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	posts := s.posts
+	s.posts = nil
+	return posts
+}
+
+var (
+	// The Slack queue and its sender are global, so every test shares one
+	// sender posting to testSink
+	testSink        = &recordingSink{}
+	startTestSender sync.Once
+)
+
+// newTestConnection reads configYAML as a config file and returns a
+// registered connection that records what it sends instead of sending it
+func newTestConnection(t *testing.T, configYAML string) (*IRCConnection, *recordingWriter) {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(file, []byte(configYAML), 0600); err != nil {
+		t.Fatal(err)
+	}
+	config, err := readConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	startTestSender.Do(func() {
+		slackQueue = newSlackMessageQueue(1000, "")
+		go runSlackSender(testSink, newRateLimiter(1000, 1000), nil)
+	})
+	testSink.Take(t)
+	lastSeen = newLastSeenState("")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ircConn := newIRCConnection(ctx, config)
+	writer := &recordingWriter{}
+	ircConn.Attach(writer, config.IRC.Nickname)
+	return ircConn, writer
+}
+
+func TestHandleMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		line      string
+		wantLines []string
+		wantPosts []string
+	}{
+		{
+			name:      "ping",
+			line:      "PING :irc.example.org",
+			wantLines: []string{"PONG :irc.example.org"},
+		},
+		{
+			name:      "join",
+			line:      ":alice!alice@example.org JOIN #chan",
+			wantPosts: []string{"*alice has joined the channel*"},
+		},
+		{
+			name:      "join with account and realname",
+			line:      ":alice!alice@example.org JOIN #chan alice :Alice Example",
+			wantPosts: []string{"*alice has joined the channel*"},
+		},
+		{
+			name:      "part",
+			line:      ":alice!alice@example.org PART #chan :see you",
+			wantPosts: []string{"*alice has left the channel*"},
+		},
+		{
+			name:      "kick reason formatting stripped",
+			line:      ":alice!alice@example.org KICK #chan bob :\x02\x0304stop\x03 that\x02",
+			wantPosts: []string{"*alice kicked bob from #chan (stop that)*"},
+		},
+		{
+			name:      "quit reason formatting stripped",
+			line:      ":alice!alice@example.org QUIT :\x1dgone\x1d fishing",
+			wantPosts: []string{"*alice has quit (gone fishing)*"},
+		},
+		{
+			name:      "action",
+			line:      ":alice!alice@example.org PRIVMSG #chan :\x01ACTION waves\x01",
+			wantPosts: []string{"_alice waves_"},
+		},
+		{
+			name:      "privmsg",
+			line:      ":alice!alice@example.org PRIVMSG #chan :hello there",
+			wantPosts: []string{"<alice> hello there"},
+		},
+		{
+			name:      "privmsg with url",
+			line:      ":alice!alice@example.org PRIVMSG #chan :see http://example.com:8080/x",
+			wantPosts: []string{"<alice> see <http://example.com:8080/x>"},
+		},
+		{
+			name: "unlisted channel",
+			line: ":alice!alice@example.org PRIVMSG #other :hello there",
+		},
+		{
+			name:      "ctcp version",
+			line:      ":alice!alice@example.org PRIVMSG bot :\x01VERSION\x01",
+			wantLines: []string{"NOTICE alice :\x01VERSION " + ctcpVersion + "\x01"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ircConn, writer := newTestConnection(t, testConfig)
+			ircConn.HandleLine(tt.line)
+
+			if lines := writer.Lines(); !slices.Equal(lines, tt.wantLines) {
+				t.Errorf("sent %q, want %q", lines, tt.wantLines)
+			}
+			var texts []string
+			for _, post := range testSink.Take(t) {
+				texts = append(texts, post.payload.Text)
+				if want := ircConn.Config().Slack.WebhookURL; post.destination != want {
+					t.Errorf("posted to %q, want %q", post.destination, want)
+				}
+			}
+			if !slices.Equal(texts, tt.wantPosts) {
+				t.Errorf("posted %q, want %q", texts, tt.wantPosts)
+			}
+		})
+	}
+}
+
+func TestPongEchoesPercentSigns(t *testing.T) {
+	for _, token := range []string{"%s", "100%", "%d%%%v", "abc%!x(MISSING)"} {
+		ircConn, writer := newTestConnection(t, testConfig)
+		ircConn.HandleLine("PING :" + token)
+		want := "PONG :" + token + "\r\n"
+		if got := writer.buf.String(); got != want {
+			t.Errorf("PING :%s sent %q, want %q", token, got, want)
+		}
+	}
+}
+
+func TestOwnMessagesAreNotBridged(t *testing.T) {
+	ircConn, writer := newTestConnection(t, testConfig)
+
+	// Relay a message from Slack, then have the server echo it back as
+	// echo-message does
+	if err := ircConn.Send("PRIVMSG #chan :<alice> hello from Slack"); err != nil {
+		t.Fatal(err)
+	}
+	sent := writer.Lines()
+	if len(sent) != 1 {
+		t.Fatalf("sent %q, want one line", sent)
+	}
+	for _, prefix := range []string{":bot!bot@example.org ", ":BOT!bot@example.org "} {
+		echo := irc.ParseLine(prefix + sent[0])
+		if !ircConn.isOwnMessage(echo) {
+			t.Errorf("isOwnMessage(%q) = false, want true", prefix+sent[0])
+		}
+		ircConn.HandleLine(prefix + sent[0])
+	}
+	if posts := testSink.Take(t); len(posts) != 0 {
+		t.Errorf("echoed message was posted to Slack: %+v", posts)
+	}
+
+	// Someone else's message still gets through, and so does ours once
+	// the server has given us another nick
+	other := irc.ParseLine(":alice!alice@example.org PRIVMSG #chan :hello")
+	if ircConn.isOwnMessage(other) {
+		t.Errorf("isOwnMessage(%q) = true, want false", ":alice!alice@example.org PRIVMSG #chan :hello")
+	}
+	ircConn.HandleLine(":irc.example.org 001 bot_ :Welcome")
+	renamed := irc.ParseLine(":bot_!bot@example.org PRIVMSG #chan :hello")
+	if !ircConn.isOwnMessage(renamed) {
+		t.Errorf("isOwnMessage after registering as bot_ = false, want true")
+	}
+	if ircConn.isOwnMessage(irc.ParseLine(":bot!bot@example.org PRIVMSG #chan :hello")) {
+		t.Errorf("isOwnMessage for the old nick after registering as bot_ = true, want false")
+	}
+
+	// Services can change our nick after registration, and echoes then
+	// come from the new one
+	ircConn.HandleLine(":bot_!bot@example.org NICK :Guest42")
+	testSink.Take(t)
+	ircConn.HandleLine(":Guest42!bot@example.org PRIVMSG #chan :<alice> hello again")
+	if posts := testSink.Take(t); len(posts) != 0 {
+		t.Errorf("echo after our nick changed was posted to Slack: %+v", posts)
+	}
+	if got := ircConn.Nick(); got != "Guest42" {
+		t.Errorf("Nick() after NICK = %q, want Guest42", got)
+	}
+
+	// Other people's nick changes leave ours alone
+	ircConn.HandleLine(":alice!alice@example.org NICK :alice_away")
+	if got := ircConn.Nick(); got != "Guest42" {
+		t.Errorf("Nick() after someone else's NICK = %q, want Guest42", got)
+	}
+}
+
+func TestStripIRCFormatting(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "hello there", "hello there"},
+		{"foreground color", "\x0304red alert\x03 over", "red alert over"},
+		{"foreground and background", "\x0308,02yellow on blue\x03", "yellow on blue"},
+		{"single digit color", "\x034red\x0f normal", "red normal"},
+		{"color then digits", "\x0303,0112345\x03", "12345"},
+		{"bare color", "\x03no color", "no color"},
+		{"hex color", "\x04ff0000,00ff00red on green\x04 done", "red on green done"},
+		{"bold underline italic", "\x02bold\x02 \x1funder\x1f \x1ditalic\x1d", "bold under italic"},
+		{"strike monospace reverse", "\x1estruck\x1e \x11code\x11 \x16rev\x16", "struck code rev"},
+		{"reset", "\x02\x0312bold blue\x0f plain", "bold blue plain"},
+		{"build bot", "\x0303[\x0302irctoslack\x0303]\x03 \x0307fred\x03 pushed \x021\x02 commit to \x0306main\x03: \x0314https://example.com/c/1\x03", "[irctoslack] fred pushed 1 commit to main: https://example.com/c/1"},
+		{"rainbow", "\x0304h\x0307e\x0308l\x0309l\x0312o\x03", "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := stripIRCFormatting(tt.text); got != tt.want {
+				t.Errorf("stripIRCFormatting(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertIRCFormatting(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"plain", "hello there", "hello there"},
+		{"bold", "a \x02bold\x02 word", "a *bold* word"},
+		{"italic", "an \x1ditalic\x1d word", "an _italic_ word"},
+		{"strike", "a \x1estruck\x1e word", "a ~struck~ word"},
+		{"colors stripped", "\x0304,01red\x03 and \x0309green\x03", "red and green"},
+		{"bold color", "\x02\x0304ERROR\x03\x02: disk full", "*ERROR*: disk full"},
+		{"unterminated", "\x02bold to the end", "*bold to the end*"},
+		{"reset closes", "\x02\x1dboth\x0f none", "*_both_* none"},
+		{"outer ends first", "\x02bold \x1dboth\x02 italic\x1d", "*bold _both_*_ italic_"},
+		{"empty run", "\x02\x02text", "text"},
+		{"underline stripped", "\x1funder\x1f", "under"},
+		{"build bot", "\x0303[\x0302irctoslack\x0303]\x03 \x02\x0304build failed\x0f on \x0306main\x03", "[irctoslack] *build failed* on main"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := convertIRCFormatting(tt.text); got != tt.want {
+				t.Errorf("convertIRCFormatting(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestActionMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		isAction bool
+		want     string
+	}{
+		{"action", "\x01ACTION waves\x01", true, "waves"},
+		{"action without closing delimiter", "\x01ACTION waves", true, "waves"},
+		{"action with trailing content", "\x01ACTION waves\x01 and more", true, "waves"},
+		{"action with colons and url", "\x01ACTION shares http://example.com:8080/x\x01", true, "shares http://example.com:8080/x"},
+		{"action with formatting", "\x01ACTION \x02really\x02 waves\x01", true, "\x02really\x02 waves"},
+		{"empty action", "\x01ACTION\x01", true, ""},
+		{"other ctcp", "\x01VERSION\x01", false, ""},
+		{"ctcp starting with action", "\x01ACTIONS list\x01", false, ""},
+		{"plain text", "ACTION waves", false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isActionMessage(tt.text); got != tt.isAction {
+				t.Errorf("isActionMessage(%q) = %v, want %v", tt.text, got, tt.isAction)
+			}
+			if !tt.isAction {
+				return
+			}
+			if got := extractActionMessage(tt.text); got != tt.want {
+				t.Errorf("extractActionMessage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestThrottledReconnectWaitsForThrottleDelay(t *testing.T) {
+	ircConn, _ := newTestConnection(t, `
+irc:
+  server: "irc.example.org:6667"
+  nickname: "bot"
+  channels: ["#chan"]
+  throttle_delay: 5m
+slack:
+  webhook_url: "https://hooks.slack.com/services/T000/B000/XXXX"
+`)
+	ircConn.HandleLine("ERROR :Closing Link: bot[example.org] (Excess Flood)")
+	if required := ircConn.ReconnectDelay(); required != 5*time.Minute {
+		t.Fatalf("after being throttled the next reconnect waits %s, want throttle_delay", required)
+	}
+}
+
+func TestPostNamesOnJoin(t *testing.T) {
+	ircConn, writer := newTestConnection(t, `
+irc:
+  server: "irc.example.org:6667"
+  nickname: "bot"
+  channels: ["#chan"]
+  post_names_on_join: true
+slack:
+  webhook_url: "https://hooks.slack.com/services/T000/B000/XXXX"
+`)
+	ircConn.HandleLine(":bot!bot@example.org JOIN #chan")
+	if lines := writer.Lines(); !slices.Equal(lines, []string{"NAMES #chan"}) {
+		t.Fatalf("after joining sent %q, want NAMES #chan", lines)
+	}
+	// The server's own reply to the JOIN, then the one to our NAMES
+	for i := 0; i < 2; i++ {
+		ircConn.HandleLine(":irc.example.org 353 bot = #chan :@alice +bob bot")
+		ircConn.HandleLine(":irc.example.org 366 bot #chan :End of /NAMES list.")
+	}
+	var names []string
+	for _, post := range testSink.Take(t) {
+		if strings.HasPrefix(post.payload.Text, "*Users in") {
+			names = append(names, post.payload.Text)
+		}
+	}
+	if want := []string{"*Users in #chan: alice, bob, bot*"}; !slices.Equal(names, want) {
+		t.Errorf("posted %q, want %q", names, want)
+	}
+
+	// Rejoining, e.g. after a kick, doesn't ask again
+	ircConn.HandleLine(":bot!bot@example.org JOIN #chan")
+	if lines := writer.Lines(); len(lines) != 1 {
+		t.Errorf("after rejoining sent %q, want nothing more", lines[1:])
+	}
+	testSink.Take(t)
+}
@@ -0,0 +1,146 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+)
+
+// DefaultAPIURL is where Web API methods are called unless APIClient.BaseURL
+// says otherwise
+const DefaultAPIURL = "https://slack.com/api/"
+
+// APIError is returned when a Web API call responds with "ok": false
+type APIError struct {
+	Method string
+	Code   string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Slack %s failed: %s", e.Method, e.Code)
+}
+
+// APIClient posts messages with the Web API's chat.postMessage using a bot
+// token. Unlike a webhook it can post to any channel the bot is in and
+// reports the ts of each message, so later posts can reply in its thread.
+type APIClient struct {
+	HTTP  *http.Client
+	Token string
+	// BaseURL overrides DefaultAPIURL
+	BaseURL string
+	// MaxRetries is how many times a failed post is retried
+	MaxRetries int
+	// DryRun logs payloads instead of posting them
+	DryRun bool
+	// OnFailure, if set, is called for every failed call, including ones
+	// that are retried
+	OnFailure func(err error)
+}
+
+// Post posts a payload to a Slack channel ID with chat.postMessage,
+// escaping its text and retrying like Client.Post. It returns the ts of the
+// new message.
+func (c *APIClient) Post(payload Payload, channel string) (string, error) {
+	payload = escapePayload(payload)
+	payload.Channel = channel
+	var result struct {
+		TS string `json:"ts"`
+	}
+	err := c.callWithRetries("chat.postMessage", payload, &result)
+	return result.TS, err
+}
+
+// Update replaces a message posted earlier with chat.update. Its author
+// can't be changed, so only the payload's text, blocks and attachments are
+// used.
+func (c *APIClient) Update(payload Payload, channel, ts string) error {
+	payload = escapePayload(payload)
+	update := struct {
+		Channel     string       `json:"channel"`
+		TS          string       `json:"ts"`
+		Text        string       `json:"text,omitempty"`
+		Blocks      []Block      `json:"blocks,omitempty"`
+		Attachments []Attachment `json:"attachments,omitempty"`
+	}{channel, ts, payload.Text, payload.Blocks, payload.Attachments}
+	return c.callWithRetries("chat.update", update, &struct{}{})
+}
+
+// Delete deletes a message posted earlier with chat.delete
+func (c *APIClient) Delete(channel, ts string) error {
+	return c.callWithRetries("chat.delete", map[string]string{
+		"channel": channel,
+		"ts":      ts,
+	}, &struct{}{})
+}
+
+// callWithRetries calls a Web API method, retrying like Client.Post. With
+// DryRun, the call is logged instead.
+func (c *APIClient) callWithRetries(method string, args interface{}, result interface{}) error {
+	// Use json.Marshal for proper encoding of emoji, newlines, backslashes, etc.
+	jsonData, err := json.Marshal(args)
+	if err != nil {
+		return fmt.Errorf("error encoding message to JSON: %w", err)
+	}
+	if c.DryRun {
+		slog.Info("Dry run, not calling Slack", "method", method, "payload", string(jsonData))
+		return nil
+	}
+	slog.Debug("Calling Slack", "method", method, "payload", string(jsonData))
+
+	return withRetries(c.MaxRetries, c.OnFailure, func() error {
+		return c.call(method, jsonData, result)
+	})
+}
+
+// AuthTest checks the token with auth.test, returning the bot's user name
+func (c *APIClient) AuthTest() (string, error) {
+	var result struct {
+		User string `json:"user"`
+	}
+	if err := c.call("auth.test", []byte("{}"), &result); err != nil {
+		return "", err
+	}
+	return result.User, nil
+}
+
+// call makes a single Web API call with a JSON body, decoding the response
+// into result
+func (c *APIClient) call(method string, jsonData []byte, result interface{}) error {
+	baseURL := c.BaseURL
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+	req, err := http.NewRequest("POST", baseURL+method, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("error creating Slack %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling Slack %s: %w", method, err)
+	}
+	defer resp.Body.Close()
+	if err := checkStatus(resp); err != nil {
+		return err
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading Slack %s response: %w", method, err)
+	}
+	var status struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &status); err != nil {
+		return fmt.Errorf("error decoding Slack %s response: %w", method, err)
+	}
+	if !status.OK {
+		return &APIError{Method: method, Code: status.Error}
+	}
+	return json.Unmarshal(body, result)
+}
@@ -0,0 +1,167 @@
+package slack
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// Regex for <...> tokens: user, channel and special mentions, and links
+	angleTokenRegex = regexp.MustCompile(`<([^<>\n]*)>`)
+	// Regexes for *bold*, _italic_, ~strike~ and `code`, which only count
+	// at word boundaries so "2*3*4" and snake_case_names are left alone
+	boldRegex   = regexp.MustCompile(`(^|[^\pL\pN*])\*([^*\n]+)\*($|[^\pL\pN*])`)
+	italicRegex = regexp.MustCompile(`(^|[^\pL\pN_])_([^_\n]+)_($|[^\pL\pN_])`)
+	strikeRegex = regexp.MustCompile(`(^|[^\pL\pN~])~([^~\n]+)~($|[^\pL\pN~])`)
+	codeRegex   = regexp.MustCompile("`([^`\n]+)`")
+	// Regex for :emoji: shortcodes
+	emojiRegex = regexp.MustCompile(`:([a-z0-9_+\-]+):`)
+	// Reverses the escaping Slack applies to &, < and >
+	unescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">")
+)
+
+// emoji maps common Slack emoji shortcodes to their Unicode characters.
+// Anything else is left as the shortcode, which reads well enough on IRC.
+var emoji = map[string]string{
+	"+1":                    "👍",
+	"-1":                    "👎",
+	"thumbsup":              "👍",
+	"thumbsdown":            "👎",
+	"smile":                 "😄",
+	"smiley":                "😃",
+	"grinning":              "😀",
+	"laughing":              "😆",
+	"joy":                   "😂",
+	"slightly_smiling_face": "🙂",
+	"wink":                  "😉",
+	"blush":                 "😊",
+	"heart_eyes":            "😍",
+	"thinking_face":         "🤔",
+	"neutral_face":          "😐",
+	"confused":              "😕",
+	"cry":                   "😢",
+	"sob":                   "😭",
+	"rage":                  "😡",
+	"scream":                "😱",
+	"sweat_smile":           "😅",
+	"upside_down_face":      "🙃",
+	"eyes":                  "👀",
+	"wave":                  "👋",
+	"clap":                  "👏",
+	"pray":                  "🙏",
+	"muscle":                "💪",
+	"ok_hand":               "👌",
+	"raised_hands":          "🙌",
+	"heart":                 "❤️",
+	"broken_heart":          "💔",
+	"fire":                  "🔥",
+	"tada":                  "🎉",
+	"rocket":                "🚀",
+	"star":                  "⭐",
+	"sparkles":              "✨",
+	"100":                   "💯",
+	"warning":               "⚠️",
+	"x":                     "❌",
+	"white_check_mark":      "✅",
+	"heavy_check_mark":      "✔️",
+	"question":              "❓",
+	"exclamation":           "❗",
+	"bug":                   "🐛",
+	"coffee":                "☕",
+	"beer":                  "🍺",
+	"rotating_light":        "🚨",
+	"point_up":              "☝️",
+	"point_right":           "👉",
+	"see_no_evil":           "🙈",
+	"shrug":                 "🤷",
+	"facepalm":              "🤦",
+}
+
+// IRC formatting codes used when converting formatting
+const (
+	ircBold      = "\x02"
+	ircItalic    = "\x1d"
+	ircStrike    = "\x1e"
+	ircMonospace = "\x11"
+)
+
+// ToIRC converts Slack message text to text for IRC. User mentions become
+// @name using userName to look up the user, channel and special mentions
+// become #channel and @here, links become "label (url)", common :emoji:
+// shortcodes become Unicode, and Slack's escaping is undone. With
+// formatting, *bold*, _italic_, ~strike~ and `code` become IRC formatting
+// codes; otherwise the markers are removed.
+func ToIRC(text string, userName func(id string) string, formatting bool) string {
+	var out strings.Builder
+	pos := 0
+	for _, loc := range angleTokenRegex.FindAllStringSubmatchIndex(text, -1) {
+		out.WriteString(convertMrkdwn(text[pos:loc[0]], formatting))
+		out.WriteString(unescaper.Replace(convertToken(text[loc[2]:loc[3]], userName)))
+		pos = loc[1]
+	}
+	out.WriteString(convertMrkdwn(text[pos:], formatting))
+	return out.String()
+}
+
+// convertToken converts the inside of a <...> token: <@U123>, <#C123|name>,
+// <!here>, <!subteam^S123|@team>, <url> or <url|label>
+func convertToken(token string, userName func(id string) string) string {
+	target, label, hasLabel := strings.Cut(token, "|")
+	switch {
+	case strings.HasPrefix(target, "@"):
+		if hasLabel && label != "" {
+			return "@" + strings.TrimPrefix(label, "@")
+		}
+		return "@" + userName(target[1:])
+	case strings.HasPrefix(target, "#"):
+		if hasLabel && label != "" {
+			return "#" + label
+		}
+		return target
+	case strings.HasPrefix(target, "!"):
+		if hasLabel && label != "" {
+			// Subteams and dates carry the text to show
+			return label
+		}
+		return "@" + strings.TrimPrefix(target, "!")
+	}
+	url := strings.TrimPrefix(target, "mailto:")
+	if !hasLabel || label == "" || label == url || label == target {
+		return url
+	}
+	return label + " (" + url + ")"
+}
+
+// convertMrkdwn converts or strips the formatting in text outside <...>
+// tokens, translates emoji and undoes Slack's escaping
+func convertMrkdwn(text string, formatting bool) string {
+	wrap := func(re *regexp.Regexp, code string) {
+		if !formatting {
+			code = ""
+		}
+		// Adjacent runs like "*a* *b*" share the space between them, so
+		// the second is only matched on another pass
+		for {
+			replaced := re.ReplaceAllString(text, "${1}"+code+"${2}"+code+"${3}")
+			if replaced == text {
+				return
+			}
+			text = replaced
+		}
+	}
+	wrap(boldRegex, ircBold)
+	wrap(italicRegex, ircItalic)
+	wrap(strikeRegex, ircStrike)
+	if formatting {
+		text = codeRegex.ReplaceAllString(text, ircMonospace+"${1}"+ircMonospace)
+	} else {
+		text = codeRegex.ReplaceAllString(text, "${1}")
+	}
+	text = emojiRegex.ReplaceAllStringFunc(text, func(shortcode string) string {
+		if e, ok := emoji[strings.Trim(shortcode, ":")]; ok {
+			return e
+		}
+		return shortcode
+	})
+	return unescaper.Replace(text)
+}
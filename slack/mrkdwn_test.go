@@ -0,0 +1,63 @@
+package slack
+
+import "testing"
+
+func TestToIRC(t *testing.T) {
+	userName := func(id string) string {
+		if id == "U123" {
+			return "alice"
+		}
+		return id
+	}
+	tests := []struct {
+		name       string
+		text       string
+		formatting bool
+		want       string
+	}{
+		// Mentions
+		{"user mention", "hi <@U123>", false, "hi @alice"},
+		{"user mention with label", "hi <@U999|bob>", false, "hi @bob"},
+		{"unknown user", "hi <@U999>", false, "hi @U999"},
+		{"channel mention", "see <#C123|general>", false, "see #general"},
+		{"special mention", "<!here> lunch", false, "@here lunch"},
+		{"subteam mention", "ping <!subteam^S123|@devs>", false, "ping @devs"},
+
+		// Links
+		{"bare link", "see <https://example.com/a>", false, "see https://example.com/a"},
+		{"labelled link", "see <https://example.com/a|the docs>", false, "see the docs (https://example.com/a)"},
+		{"link labelled with itself", "<https://example.com|https://example.com>", false, "https://example.com"},
+		{"mailto", "<mailto:a@example.com|a@example.com>", false, "a@example.com"},
+		{"escaped link label", "<https://example.com/?a=1&amp;b=2|a &amp; b>", false, "a & b (https://example.com/?a=1&b=2)"},
+
+		// Emoji
+		{"known emoji", "ship it :rocket:", false, "ship it 🚀"},
+		{"thumbs up", ":+1:", false, "👍"},
+		{"unknown emoji", ":partyparrot:", false, ":partyparrot:"},
+		{"not emoji", "at 10:30:00", false, "at 10:30:00"},
+
+		// Formatting
+		{"bold stripped", "a *bold* word", false, "a bold word"},
+		{"bold", "a *bold* word", true, "a \x02bold\x02 word"},
+		{"italic stripped", "an _italic_ word", false, "an italic word"},
+		{"italic", "an _italic_ word", true, "an \x1ditalic\x1d word"},
+		{"strike stripped", "a ~struck~ word", false, "a struck word"},
+		{"strike", "a ~struck~ word", true, "a \x1estruck\x1e word"},
+		{"code stripped", "run `make`", false, "run make"},
+		{"code", "run `make`", true, "run \x11make\x11"},
+		{"adjacent runs", "*a* *b*", true, "\x02a\x02 \x02b\x02"},
+		{"arithmetic", "2*3*4", true, "2*3*4"},
+		{"snake case", "snake_case_name", true, "snake_case_name"},
+
+		// Escaping
+		{"entities", "a &lt; b &amp;&amp; c &gt; d", false, "a < b && c > d"},
+		{"entity not double unescaped", "&amp;lt;", false, "&lt;"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ToIRC(tt.text, userName, tt.formatting); got != tt.want {
+				t.Errorf("ToIRC(%q, %v) = %q, want %q", tt.text, tt.formatting, got, tt.want)
+			}
+		})
+	}
+}
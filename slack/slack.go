@@ -0,0 +1,131 @@
+// Package slack builds and posts messages for Slack incoming webhooks
+package slack
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Payload is the JSON body posted to a Slack incoming webhook, or to
+// chat.postMessage, which also takes Channel and ThreadTS
+type Payload struct {
+	Channel     string       `json:"channel,omitempty"`
+	ThreadTS    string       `json:"thread_ts,omitempty"`
+	Text        string       `json:"text,omitempty"`
+	Blocks      []Block      `json:"blocks,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+	Username    string       `json:"username,omitempty"`
+	IconEmoji   string       `json:"icon_emoji,omitempty"`
+	IconURL     string       `json:"icon_url,omitempty"`
+	// Mention is added to the end of Text once it has been escaped, so it
+	// can be a special mention such as <!here>
+	Mention string `json:"-"`
+}
+
+// Block is a Block Kit layout block. Sections have Text, context blocks
+// have Elements, and image blocks have ImageURL and AltText.
+type Block struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	Elements []Text `json:"elements,omitempty"`
+	ImageURL string `json:"image_url,omitempty"`
+	AltText  string `json:"alt_text,omitempty"`
+}
+
+// Attachment is a legacy message attachment, used for its colored bar
+type Attachment struct {
+	Color    string `json:"color"`
+	Text     string `json:"text"`
+	Fallback string `json:"fallback"`
+}
+
+// Text is a Block Kit text object
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+var (
+	// Regex for URLs in message text
+	urlRegex = regexp.MustCompile(`https?://[^\s<>"|]+`)
+	// Escapes the characters Slack treats as control characters in text
+	escaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	// Regex for <url> links, user mentions and HTML entities, which
+	// EscapeText keeps
+	tokenRegex = regexp.MustCompile(`<https?://[^<>\s]*>|<@[UW][A-Z0-9]+>|&(amp|lt|gt);`)
+)
+
+// LinkURLs wraps URLs in <url> so Slack links them exactly, rather than
+// guessing where they end
+func LinkURLs(text string) string {
+	var out strings.Builder
+	pos := 0
+	for _, span := range URLSpans(text) {
+		out.WriteString(text[pos:span[0]])
+		out.WriteString("<" + text[span[0]:span[1]] + ">")
+		pos = span[1]
+	}
+	out.WriteString(text[pos:])
+	return out.String()
+}
+
+// URLSpans returns the start and end of each URL in text, as LinkURLs
+// links them
+func URLSpans(text string) [][]int {
+	spans := urlRegex.FindAllStringIndex(text, -1)
+	for _, span := range spans {
+		span[1] = urlEnd(text, span)
+	}
+	return spans
+}
+
+// ImageURLs returns the URLs in text whose path ends in a common image
+// extension, such as .png or .jpg
+func ImageURLs(text string) []string {
+	var images []string
+	for _, loc := range urlRegex.FindAllStringIndex(text, -1) {
+		link := text[loc[0]:urlEnd(text, loc)]
+		u, err := url.Parse(link)
+		if err != nil {
+			continue
+		}
+		switch strings.ToLower(path.Ext(u.Path)) {
+		case ".png", ".jpg", ".jpeg", ".gif", ".webp":
+			images = append(images, link)
+		}
+	}
+	return images
+}
+
+// urlEnd returns where a URL matched at loc ends, leaving trailing
+// punctuation, e.g. a full stop ending a sentence or the closing _ of an
+// action, outside it
+func urlEnd(text string, loc []int) int {
+	end := loc[1]
+	for end > loc[0] && strings.ContainsRune(".,;:!?'\")*_~", rune(text[end-1])) {
+		end--
+	}
+	return end
+}
+
+// EscapeText escapes &, < and >, which Slack treats as control characters,
+// leaving <url> links, <@user> mentions and existing entities alone so text
+// isn't escaped twice
+func EscapeText(text string) string {
+	var out strings.Builder
+	pos := 0
+	for _, loc := range tokenRegex.FindAllStringIndex(text, -1) {
+		out.WriteString(escaper.Replace(text[pos:loc[0]]))
+		token := text[loc[0]:loc[1]]
+		if strings.HasPrefix(token, "<http") {
+			// A bare & in a link's URL still needs escaping
+			token = "<" + EscapeText(token[1:len(token)-1]) + ">"
+		}
+		out.WriteString(token)
+		pos = loc[1]
+	}
+	out.WriteString(escaper.Replace(text[pos:]))
+	return out.String()
+}
@@ -0,0 +1,48 @@
+package slack
+
+import "testing"
+
+func TestLinkURLs(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"no urls", "hello there", "hello there"},
+		{"one url", "see https://example.com/a", "see <https://example.com/a>"},
+		{"two urls", "http://a.example.com and https://b.example.com/x?y=1", "<http://a.example.com> and <https://b.example.com/x?y=1>"},
+		{"adjacent urls", "https://a.example.com https://b.example.com", "<https://a.example.com> <https://b.example.com>"},
+		{"url with port", "http://example.com:8080/x", "<http://example.com:8080/x>"},
+		{"trailing punctuation", "go to https://example.com/a. Or https://example.com/b, or (https://example.com/c)", "go to <https://example.com/a>. Or <https://example.com/b>, or (<https://example.com/c>)"},
+		{"inside action", "_waves at https://example.com_", "_waves at <https://example.com>_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := LinkURLs(tt.text); got != tt.want {
+				t.Errorf("LinkURLs(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapeText(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"control characters", "a < b && c > d", "a &lt; b &amp;&amp; c &gt; d"},
+		{"existing entities", "&lt;b&gt; &amp; co", "&lt;b&gt; &amp; co"},
+		{"mention kept", "hi <@U123>", "hi <@U123>"},
+		{"links kept", "<https://a.example.com> < <https://b.example.com>", "<https://a.example.com> &lt; <https://b.example.com>"},
+		{"ampersand in link", "<https://example.com/?a=1&b=2>", "<https://example.com/?a=1&amp;b=2>"},
+		{"linked then escaped", LinkURLs("x<y https://a.example.com/?q=1&r=2 and https://b.example.com"), "x&lt;y <https://a.example.com/?q=1&amp;r=2> and <https://b.example.com>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := EscapeText(tt.text); got != tt.want {
+				t.Errorf("EscapeText(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
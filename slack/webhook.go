@@ -0,0 +1,175 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Failed posts are retried starting at this delay, doubling each time
+	retryBaseDelay = 1 * time.Second
+
+	// Longest error response body kept in an HTTPError
+	maxErrorBodyLength = 500
+)
+
+// HTTPError is returned when Slack responds with a non-OK status. Body holds
+// the start of the response, which explains the error, e.g. "no_service"
+// or "invalid_payload".
+type HTTPError struct {
+	Status     string
+	StatusCode int
+	RetryAfter time.Duration
+	Body       string
+}
+
+func (e *HTTPError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("received non-OK response from Slack: %s", e.Status)
+	}
+	return fmt.Sprintf("received non-OK response from Slack: %s: %s", e.Status, e.Body)
+}
+
+// IsRetryable reports whether a failed post may succeed later: network
+// errors, rate limiting and server errors
+func IsRetryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return false
+	}
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return true
+	}
+	return httpErr.StatusCode == http.StatusTooManyRequests || httpErr.StatusCode >= 500
+}
+
+// Client posts payloads to Slack incoming webhooks
+type Client struct {
+	HTTP *http.Client
+	// MaxRetries is how many times a failed post is retried
+	MaxRetries int
+	// DryRun logs payloads instead of posting them
+	DryRun bool
+	// OnFailure, if set, is called for every failed POST, including ones
+	// that are retried
+	OnFailure func(err error)
+}
+
+// Post posts a payload to a webhook, escaping its text. Network errors, 5xx
+// responses and rate limiting (429) are retried with backoff up to
+// MaxRetries times before giving up. Webhooks don't say which message they
+// created, so the returned ts is always empty.
+func (c *Client) Post(payload Payload, webhookURL string) (string, error) {
+	// Use json.Marshal for proper encoding of emoji, newlines, backslashes, etc.
+	jsonData, err := json.Marshal(escapePayload(payload))
+	if err != nil {
+		return "", fmt.Errorf("error encoding message to JSON: %w", err)
+	}
+	if c.DryRun {
+		slog.Info("Dry run, not posting to Slack", "webhook", webhookURL, "payload", string(jsonData))
+		return "", nil
+	}
+	slog.Debug("Posting to Slack", "payload", string(jsonData))
+
+	return "", withRetries(c.MaxRetries, c.OnFailure, func() error {
+		return c.send(jsonData, webhookURL)
+	})
+}
+
+// escapePayload escapes the text of a payload and its attachments, copying
+// the attachments so a queued post isn't escaped twice if it's retried, and
+// adds its mention
+func escapePayload(payload Payload) Payload {
+	payload.Text = EscapeText(payload.Text)
+	if payload.Text == "" {
+		// Event posts carry their text in an attachment
+		payload.Text = payload.Mention
+	} else if payload.Mention != "" {
+		payload.Text += " " + payload.Mention
+	}
+	if len(payload.Attachments) > 0 {
+		attachments := make([]Attachment, len(payload.Attachments))
+		for i, attachment := range payload.Attachments {
+			attachment.Text = EscapeText(attachment.Text)
+			attachment.Fallback = EscapeText(attachment.Fallback)
+			attachments[i] = attachment
+		}
+		payload.Attachments = attachments
+	}
+	return payload
+}
+
+// withRetries calls send until it succeeds, fails with an error that isn't
+// retryable, or has been retried maxRetries times, doubling the delay
+// between attempts unless Slack asks for a specific one
+func withRetries(maxRetries int, onFailure func(err error), send func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := send()
+		if err == nil {
+			return nil
+		}
+		if onFailure != nil {
+			onFailure(err)
+		}
+
+		if !IsRetryable(err) || attempt > maxRetries {
+			return err
+		}
+
+		wait := delay
+		var httpErr *HTTPError
+		if errors.As(err, &httpErr) && httpErr.RetryAfter > 0 {
+			wait = httpErr.RetryAfter
+		}
+		slog.Warn("Error posting to Slack, retrying", "attempt", attempt, "attempts", maxRetries+1, "delay", wait, "err", err)
+		time.Sleep(wait)
+		delay *= 2
+	}
+}
+
+// send makes a single POST to a webhook
+func (c *Client) send(jsonData []byte, webhookURL string) error {
+	resp, err := c.HTTP.Post(webhookURL, "application/json", bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("error sending message to Slack: %w", err)
+	}
+	defer resp.Body.Close()
+	// Read the body to the end so the connection can be reused
+	defer io.Copy(ioutil.Discard, resp.Body)
+
+	return checkStatus(resp)
+}
+
+// checkStatus returns an HTTPError if Slack responded with a non-OK status,
+// including the start of the response body
+func checkStatus(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK {
+		httpErr := &HTTPError{Status: resp.Status, StatusCode: resp.StatusCode}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			httpErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBodyLength+1))
+		truncated := len(body) > maxErrorBodyLength
+		if truncated {
+			body = body[:maxErrorBodyLength]
+		}
+		// Cutting the body may split a UTF-8 character, so drop any partial one
+		httpErr.Body = strings.TrimSpace(strings.ToValidUTF8(string(body), ""))
+		if truncated {
+			httpErr.Body += "…"
+		}
+		return httpErr
+	}
+	return nil
+}
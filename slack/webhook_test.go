@@ -0,0 +1,22 @@
+package slack
+
+import "testing"
+
+func TestEscapePayloadMention(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		want    string
+	}{
+		{"no mention", Payload{Text: "a < b"}, "a &lt; b"},
+		{"mention", Payload{Text: "a < b", Mention: "<!here>"}, "a &lt; b <!here>"},
+		{"attachment", Payload{Attachments: []Attachment{{Text: "a < b"}}, Mention: "<!here>"}, "<!here>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapePayload(tt.payload).Text; got != tt.want {
+				t.Errorf("escapePayload(%+v).Text = %q, want %q", tt.payload, got, tt.want)
+			}
+		})
+	}
+}